@@ -0,0 +1,229 @@
+// Package alidns implements the "alidnschallenger" DNS01 solver, backed by
+// Alibaba Cloud DNS.
+package alidns
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/alidns"
+	"github.com/jetstack/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+
+	"github.com/signcl/cert-manager-webhook-dnspod/internal/solverutil"
+)
+
+const defaultRegionID = "cn-hangzhou"
+
+type apiTokenSecretRef struct {
+	name      string
+	namespace string
+}
+
+// customDNSProviderConfig is decoded from the Issuer's solver config.
+type customDNSProviderConfig struct {
+	APITokenSecret apiTokenSecretRef `json:"apiTokenSecret"`
+	TTL            int               `json:"ttl"`
+	RegionID       string            `json:"regionId"`
+
+	// IssuerCAAIdentity is the CAA issuer value Present checks the resolved
+	// FQDN's CAA RRset against before creating the challenge record.
+	// Defaults to solverutil.DefaultIssuerCAAIdentity.
+	IssuerCAAIdentity string `json:"issuerCAAIdentity"`
+}
+
+// Solver implements the provider-specific logic needed to 'present' an ACME
+// challenge TXT record with Alibaba Cloud DNS.
+type Solver struct {
+	client *kubernetes.Clientset
+	aliDNS *solverutil.ClientCache
+}
+
+// NewSolver returns an Alibaba Cloud DNS DNS01 solver, ready to be
+// registered with cmd.RunWebhookServer.
+func NewSolver() *Solver {
+	return &Solver{}
+}
+
+// Name is used as the name for this DNS solver when referencing it on the
+// ACME Issuer resource.
+func (c *Solver) Name() string {
+	return "alidnschallenger"
+}
+
+// Present is responsible for actually presenting the DNS record with the
+// DNS provider.
+func (c *Solver) Present(ch *v1alpha1.ChallengeRequest) error {
+	cfg, err := loadConfig(ch.Config)
+	if err != nil {
+		return err
+	}
+
+	if err := solverutil.CheckCAA(ch.DNSName, ch.ResolvedFQDN, ch.ResolvedZone, cfg.IssuerCAAIdentity); err != nil {
+		return err
+	}
+
+	client, err := c.getClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	authZone, err := solverutil.AuthoritativeZone(ch.ResolvedZone)
+	if err != nil {
+		return err
+	}
+	recordName := solverutil.ExtractRecordName(ch.ResolvedFQDN, ch.ResolvedZone)
+	domainName := stripTrailingDot(authZone)
+
+	// Present must tolerate being called multiple times with the same
+	// value -- and AliDNS rejects a duplicate AddDomainRecord for the same
+	// RR+value with DomainRecordDuplicate, so check for it first rather
+	// than erroring on cert-manager's retries.
+	describeRequest := alidns.CreateDescribeDomainRecordsRequest()
+	describeRequest.DomainName = domainName
+	describeRequest.RRKeyWord = recordName
+	describeRequest.Type = "TXT"
+
+	describeResponse, err := client.DescribeDomainRecords(describeRequest)
+	if err != nil {
+		return fmt.Errorf("alidns API call failed: %v", err)
+	}
+	for _, record := range describeResponse.DomainRecords.Record {
+		if record.RR == recordName && record.Value == ch.Key {
+			return nil
+		}
+	}
+
+	request := alidns.CreateAddDomainRecordRequest()
+	request.DomainName = domainName
+	request.RR = recordName
+	request.Type = "TXT"
+	request.Value = ch.Key
+	request.TTL = fmt.Sprintf("%d", cfg.TTL)
+
+	if _, err := client.AddDomainRecord(request); err != nil {
+		return fmt.Errorf("alidns API call failed: %v", err)
+	}
+
+	return nil
+}
+
+// CleanUp should delete the relevant TXT record from the DNS provider console.
+func (c *Solver) CleanUp(ch *v1alpha1.ChallengeRequest) error {
+	cfg, err := loadConfig(ch.Config)
+	if err != nil {
+		return err
+	}
+
+	client, err := c.getClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	authZone, err := solverutil.AuthoritativeZone(ch.ResolvedZone)
+	if err != nil {
+		return err
+	}
+	recordName := solverutil.ExtractRecordName(ch.ResolvedFQDN, ch.ResolvedZone)
+
+	describeRequest := alidns.CreateDescribeDomainRecordsRequest()
+	describeRequest.DomainName = stripTrailingDot(authZone)
+	describeRequest.RRKeyWord = recordName
+	describeRequest.Type = "TXT"
+
+	response, err := client.DescribeDomainRecords(describeRequest)
+	if err != nil {
+		return fmt.Errorf("alidns API call failed: %v", err)
+	}
+
+	for _, record := range response.DomainRecords.Record {
+		if record.Value != ch.Key {
+			continue
+		}
+
+		deleteRequest := alidns.CreateDeleteDomainRecordRequest()
+		deleteRequest.RecordId = record.RecordId
+		if _, err := client.DeleteDomainRecord(deleteRequest); err != nil {
+			return fmt.Errorf("alidns API call failed: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Initialize will be called when the webhook first starts.
+func (c *Solver) Initialize(kubeClientConfig *rest.Config, stopCh <-chan struct{}) error {
+	cl, err := kubernetes.NewForConfig(kubeClientConfig)
+	if err != nil {
+		return err
+	}
+	c.client = cl
+	c.aliDNS = solverutil.NewClientCache()
+
+	return nil
+}
+
+func (c *Solver) getClient(cfg customDNSProviderConfig) (*alidns.Client, error) {
+	secretNS := cfg.APITokenSecret.namespace
+	secretName := cfg.APITokenSecret.name
+	secretFQN := solverutil.SecretFQN(secretNS, secretName)
+
+	secret, err := solverutil.GetSecret(c.client, secretNS, secretName)
+	if err != nil {
+		return nil, err
+	}
+
+	if cached, ok := c.aliDNS.Get(secretFQN, secret.ResourceVersion); ok {
+		return cached.(*alidns.Client), nil
+	}
+
+	accessKeyID, ok := secret.Data["accessKeyId"]
+	if !ok {
+		return nil, fmt.Errorf("no `accessKeyId` in secret '%s'", secretFQN)
+	}
+	accessKeySecret, ok := secret.Data["accessKeySecret"]
+	if !ok {
+		return nil, fmt.Errorf("no `accessKeySecret` in secret '%s'", secretFQN)
+	}
+
+	regionID := cfg.RegionID
+	if regionID == "" {
+		regionID = defaultRegionID
+	}
+
+	client, err := alidns.NewClientWithAccessKey(regionID, string(accessKeyID), string(accessKeySecret))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build alidns client: %v", err)
+	}
+
+	c.aliDNS.Set(secretFQN, secret.ResourceVersion, client)
+
+	return client, nil
+}
+
+// loadConfig decodes the JSON configuration into the typed config struct.
+func loadConfig(cfgJSON *extapi.JSON) (customDNSProviderConfig, error) {
+	cfg := customDNSProviderConfig{
+		APITokenSecret: apiTokenSecretRef{
+			name:      "alidns-credentials",
+			namespace: "default",
+		},
+		TTL: solverutil.DefaultTTL,
+	}
+	if cfgJSON == nil {
+		return cfg, nil
+	}
+	if err := json.Unmarshal(cfgJSON.Raw, &cfg); err != nil {
+		return cfg, fmt.Errorf("error decoding solver config: %v", err)
+	}
+
+	return cfg, nil
+}
+
+func stripTrailingDot(s string) string {
+	return strings.TrimSuffix(s, ".")
+}
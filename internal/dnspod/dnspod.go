@@ -0,0 +1,469 @@
+// Package dnspod implements the "dnspodchallenger" DNS01 solver: the
+// original DNSPod provider, supporting the legacy LoginToken API (regions
+// "cn" and "intl") and the Tencent Cloud DNSPod v3 API (region
+// "tencentcloud").
+package dnspod
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/jetstack/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+
+	"github.com/kaelzhang/dnspod-go"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	tcerrors "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
+	dnspodv3 "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/dnspod/v20210323"
+
+	"github.com/signcl/cert-manager-webhook-dnspod/internal/solverutil"
+)
+
+const (
+	defaultHTTPTimeout = 30 * time.Second
+
+	// regionCN is the legacy, mainland-China-only DNSPod API served from
+	// api.dnspod.cn, authenticated with a LoginToken (id,token).
+	regionCN = "cn"
+	// regionIntl is the international DNSPod API served from api.dnspod.com,
+	// using the same LoginToken authentication scheme as regionCN.
+	regionIntl = "intl"
+	// regionTencentCloud uses the modern Tencent Cloud DNSPod v3 API,
+	// authenticated with a Tencent Cloud SecretId/SecretKey pair.
+	regionTencentCloud = "tencentcloud"
+
+	dnspodIntlBaseURL = "api.dnspod.com"
+)
+
+// txtRecordBackend is implemented once per DNSPod authentication scheme so
+// that Present/CleanUp don't need to know which one they're talking to.
+type txtRecordBackend interface {
+	CreateTXTRecord(zone, fqdn, value string, ttl int) error
+	DeleteTXTRecord(zone, fqdn, value string) error
+}
+
+// apiTokenSecretRef is a reference to the secret holding this provider's
+// credentials.
+type apiTokenSecretRef struct {
+	name      string
+	namespace string
+}
+
+// customDNSProviderConfig is a structure that is used to decode into when
+// solving a DNS01 challenge.
+// This information is provided by cert-manager, and may be a reference to
+// additional configuration that's needed to solve the challenge for this
+// particular certificate or issuer.
+type customDNSProviderConfig struct {
+	APITokenSecret apiTokenSecretRef `json:"apiTokenSecret"`
+	TTL            int               `json:"ttl"`
+
+	// HTTPTimeoutSeconds bounds how long a single DNSPod API call may take.
+	// Falls back to the DNSPOD_HTTP_TIMEOUT env var, then defaultHTTPTimeout.
+	//
+	// Note: cert-manager's DNS01 propagation check timeout and polling
+	// interval are configured on the Issuer/ClusterIssuer's dns01 stanza,
+	// not passed to or readable by the webhook -- there is no extension
+	// point here for this solver to influence them, so this config
+	// intentionally only covers the one timeout the solver itself controls.
+	HTTPTimeoutSeconds int `json:"httpTimeoutSeconds"`
+
+	// Region selects which DNSPod backend to talk to: "cn" (default, legacy
+	// api.dnspod.cn LoginToken API), "intl" (api.dnspod.com, same LoginToken
+	// API), or "tencentcloud" (the DNSPod v3 API, authenticated with a
+	// Tencent Cloud SecretId/SecretKey pair).
+	Region string `json:"region"`
+
+	// IssuerCAAIdentity is the CAA issuer value Present checks the resolved
+	// FQDN's CAA RRset against before creating the challenge record.
+	// Defaults to solverutil.DefaultIssuerCAAIdentity.
+	IssuerCAAIdentity string `json:"issuerCAAIdentity"`
+}
+
+// Solver implements the provider-specific logic needed to 'present' an ACME
+// challenge TXT record with DNSPod.
+// To do so, it must implement the `github.com/jetstack/cert-manager/pkg/acme/webhook.Solver`
+// interface.
+type Solver struct {
+	client   *kubernetes.Clientset
+	backends *solverutil.ClientCache
+}
+
+// NewSolver returns a DNSPod DNS01 solver, ready to be registered with
+// cmd.RunWebhookServer.
+func NewSolver() *Solver {
+	return &Solver{}
+}
+
+// Name is used as the name for this DNS solver when referencing it on the
+// ACME Issuer resource.
+func (c *Solver) Name() string {
+	return "dnspodchallenger"
+}
+
+// Present is responsible for actually presenting the DNS record with the
+// DNS provider.
+// This method should tolerate being called multiple times with the same value.
+// cert-manager itself will later perform a self check to ensure that the
+// solver has correctly configured the DNS provider.
+func (c *Solver) Present(ch *v1alpha1.ChallengeRequest) error {
+	cfg, err := loadConfig(ch.Config)
+	if err != nil {
+		return err
+	}
+
+	if err := solverutil.CheckCAA(ch.DNSName, ch.ResolvedFQDN, ch.ResolvedZone, cfg.IssuerCAAIdentity); err != nil {
+		return err
+	}
+
+	backend, err := c.getBackend(cfg)
+	if err != nil {
+		return err
+	}
+
+	return backend.CreateTXTRecord(ch.ResolvedZone, ch.ResolvedFQDN, ch.Key, cfg.TTL)
+}
+
+// CleanUp should delete the relevant TXT record from the DNS provider console.
+// If multiple TXT records exist with the same record name (e.g.
+// _acme-challenge.example.com) then **only** the record with the same `key`
+// value provided on the ChallengeRequest should be cleaned up.
+// This is in order to facilitate multiple DNS validations for the same domain
+// concurrently.
+func (c *Solver) CleanUp(ch *v1alpha1.ChallengeRequest) error {
+	cfg, err := loadConfig(ch.Config)
+	if err != nil {
+		return err
+	}
+
+	backend, err := c.getBackend(cfg)
+	if err != nil {
+		return err
+	}
+
+	return backend.DeleteTXTRecord(ch.ResolvedZone, ch.ResolvedFQDN, ch.Key)
+}
+
+// Initialize will be called when the webhook first starts.
+func (c *Solver) Initialize(kubeClientConfig *rest.Config, stopCh <-chan struct{}) error {
+	cl, err := kubernetes.NewForConfig(kubeClientConfig)
+	if err != nil {
+		return err
+	}
+	c.client = cl
+	c.backends = solverutil.NewClientCache()
+
+	return nil
+}
+
+// getBackend resolves (and caches) the txtRecordBackend to use for this
+// challenge, based on cfg.Region.
+func (c *Solver) getBackend(cfg customDNSProviderConfig) (txtRecordBackend, error) {
+	secretNS := cfg.APITokenSecret.namespace
+	secretName := cfg.APITokenSecret.name
+	secretFQN := solverutil.SecretFQN(secretNS, secretName)
+
+	secret, err := solverutil.GetSecret(c.client, secretNS, secretName)
+	if err != nil {
+		return nil, err
+	}
+
+	if cached, ok := c.backends.Get(secretFQN, secret.ResourceVersion); ok {
+		return cached.(txtRecordBackend), nil
+	}
+
+	var backend txtRecordBackend
+	switch cfg.Region {
+	case regionTencentCloud:
+		backend, err = newTencentCloudBackend(secret, secretFQN)
+	default:
+		backend, err = newDnspodBackend(secret, secretFQN, cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.backends.Set(secretFQN, secret.ResourceVersion, backend)
+
+	return backend, nil
+}
+
+// loadConfig is a small helper function that decodes JSON configuration into
+// the typed config struct.
+func loadConfig(cfgJSON *extapi.JSON) (customDNSProviderConfig, error) {
+	cfg := customDNSProviderConfig{
+		APITokenSecret: apiTokenSecretRef{
+			name:      "dnspod-credentials",
+			namespace: "default",
+		},
+		TTL:                envOrDefaultInt("DNSPOD_TTL", solverutil.DefaultTTL),
+		HTTPTimeoutSeconds: envOrDefaultInt("DNSPOD_HTTP_TIMEOUT", int(defaultHTTPTimeout.Seconds())),
+	}
+	// handle the 'base case' where no configuration has been provided
+	if cfgJSON == nil {
+		return cfg, nil
+	}
+	if err := json.Unmarshal(cfgJSON.Raw, &cfg); err != nil {
+		return cfg, fmt.Errorf("error decoding solver config: %v", err)
+	}
+
+	return cfg, nil
+}
+
+// envOrDefaultInt reads an integer from the named environment variable,
+// falling back to def if the variable is unset or not a valid integer. This
+// lets operators set the TTL (DNSPOD_TTL) and HTTP timeout
+// (DNSPOD_HTTP_TIMEOUT) cluster-wide without touching every Issuer's solver
+// config; no retry or propagation setting is read from the environment.
+func envOrDefaultInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	parsed, err := strconv.Atoi(v)
+	if err != nil {
+		return def
+	}
+	return parsed
+}
+
+// httpTimeout resolves the configured HTTP timeout, falling back to
+// defaultHTTPTimeout if unset.
+func httpTimeout(cfg customDNSProviderConfig) time.Duration {
+	if cfg.HTTPTimeoutSeconds <= 0 {
+		return defaultHTTPTimeout
+	}
+	return time.Duration(cfg.HTTPTimeoutSeconds) * time.Second
+}
+
+// httpClientWithTimeout builds the *http.Client used by the dnspod-go client,
+// replacing the library's zero-value (no timeout) default so that a slow or
+// unreachable API does not hang the webhook indefinitely. Its transport also
+// sets the webhook's User-Agent and records structured logs/metrics for
+// every legacy-API (regionCN/regionIntl) call.
+func httpClientWithTimeout(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: newLoggingTransport(nil),
+	}
+}
+
+// dnspodBackend implements txtRecordBackend against the legacy LoginToken
+// API, used for both the "cn" (api.dnspod.cn) and "intl" (api.dnspod.com)
+// regions -- they differ only in BaseURL.
+type dnspodBackend struct {
+	client *dnspod.Client
+}
+
+// newDnspodBackend builds a dnspodBackend from the `id`/`token` keys of
+// secret, pointed at the endpoint appropriate for cfg.Region.
+func newDnspodBackend(secret *corev1.Secret, secretFQN string, cfg customDNSProviderConfig) (*dnspodBackend, error) {
+	apiId, ok := secret.Data["id"]
+	if !ok {
+		return nil, fmt.Errorf("no `id` in secret '%s'", secretFQN)
+	}
+	apiToken, ok := secret.Data["token"]
+	if !ok {
+		return nil, fmt.Errorf("no `token` in secret '%s'", secretFQN)
+	}
+
+	key := fmt.Sprintf("%s,%s", apiId, apiToken)
+	params := dnspod.CommonParams{LoginToken: key, Format: "json"}
+	client := dnspod.NewClient(params)
+	client.HttpClient = httpClientWithTimeout(httpTimeout(cfg))
+	if cfg.Region == regionIntl {
+		client.BaseURL = fmt.Sprintf("https://%s/", dnspodIntlBaseURL)
+	}
+
+	return &dnspodBackend{client: client}, nil
+}
+
+func (b *dnspodBackend) CreateTXTRecord(zone, fqdn, value string, ttl int) error {
+	domainID, err := b.getDomainID(zone)
+	if err != nil {
+		return err
+	}
+
+	recordAttributes := newTxtRecord(zone, fqdn, value, ttl)
+	_, _, err = b.client.Domains.CreateRecord(domainID, *recordAttributes)
+	if err != nil {
+		return fmt.Errorf("dnspod API call failed: %v", err)
+	}
+
+	return nil
+}
+
+func (b *dnspodBackend) DeleteTXTRecord(zone, fqdn, value string) error {
+	domainID, err := b.getDomainID(zone)
+	if err != nil {
+		return err
+	}
+
+	records, err := b.findTxtRecords(domainID, zone, fqdn)
+	if err != nil && !strings.Contains(err.Error(), "No records") {
+		return err
+	}
+
+	for _, record := range records {
+		if record.Value != value {
+			continue
+		}
+
+		if _, err := b.client.Domains.DeleteRecord(domainID, record.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *dnspodBackend) getDomainID(zone string) (string, error) {
+	domains, _, err := b.client.Domains.List()
+	if err != nil {
+		return "", fmt.Errorf("dnspod API call failed: %v", err)
+	}
+
+	authZone, err := solverutil.AuthoritativeZone(zone)
+	if err != nil {
+		return "", err
+	}
+
+	var hostedDomain dnspod.Domain
+	for _, domain := range domains {
+		if domain.Name == unFqdn(authZone) {
+			hostedDomain = domain
+			break
+		}
+	}
+
+	hostedDomainID, err := hostedDomain.ID.Int64()
+	if err != nil {
+		return "", err
+	}
+	if hostedDomainID == 0 {
+		return "", fmt.Errorf("Zone %s not found in dnspod for zone %s", authZone, zone)
+	}
+
+	return fmt.Sprintf("%d", hostedDomainID), nil
+}
+
+func (b *dnspodBackend) findTxtRecords(domainID, zone, fqdn string) ([]dnspod.Record, error) {
+	recordName := solverutil.ExtractRecordName(fqdn, zone)
+	records, _, err := b.client.Domains.ListRecords(domainID, recordName)
+	if err != nil {
+		return records, fmt.Errorf("dnspod API call has failed: %v", err)
+	}
+
+	return records, nil
+}
+
+func newTxtRecord(zone, fqdn, value string, ttl int) *dnspod.Record {
+	name := solverutil.ExtractRecordName(fqdn, zone)
+
+	return &dnspod.Record{
+		Type:  "TXT",
+		Name:  name,
+		Value: value,
+		Line:  "默认",
+		TTL:   fmt.Sprintf("%d", ttl),
+	}
+}
+
+func unFqdn(s string) string {
+	if strings.HasSuffix(s, ".") {
+		return s[:len(s)-1]
+	}
+	return s
+}
+
+// tencentCloudBackend implements txtRecordBackend against the modern
+// Tencent Cloud DNSPod v3 API (CreateRecord/DeleteRecord/DescribeRecordList),
+// authenticated with a Tencent Cloud SecretId/SecretKey pair.
+type tencentCloudBackend struct {
+	client *dnspodv3.Client
+}
+
+// newTencentCloudBackend builds a tencentCloudBackend from the
+// `secretId`/`secretKey` keys of secret.
+func newTencentCloudBackend(secret *corev1.Secret, secretFQN string) (*tencentCloudBackend, error) {
+	secretId, ok := secret.Data["secretId"]
+	if !ok {
+		return nil, fmt.Errorf("no `secretId` in secret '%s'", secretFQN)
+	}
+	secretKey, ok := secret.Data["secretKey"]
+	if !ok {
+		return nil, fmt.Errorf("no `secretKey` in secret '%s'", secretFQN)
+	}
+
+	credential := common.NewCredential(string(secretId), string(secretKey))
+	clientProfile := profile.NewClientProfile()
+	client, err := dnspodv3.NewClient(credential, "", clientProfile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build tencentcloud dnspod client: %v", err)
+	}
+
+	return &tencentCloudBackend{client: client}, nil
+}
+
+func (b *tencentCloudBackend) CreateTXTRecord(zone, fqdn, value string, ttl int) error {
+	domain := unFqdn(zone)
+	name := solverutil.ExtractRecordName(fqdn, zone)
+
+	request := dnspodv3.NewCreateRecordRequest()
+	request.Domain = common.StringPtr(domain)
+	request.SubDomain = common.StringPtr(name)
+	request.RecordType = common.StringPtr("TXT")
+	request.RecordLine = common.StringPtr("默认")
+	request.Value = common.StringPtr(value)
+	request.TTL = common.Uint64Ptr(uint64(ttl))
+
+	if _, err := b.client.CreateRecord(request); err != nil {
+		return fmt.Errorf("tencentcloud dnspod API call failed: %v", err)
+	}
+
+	return nil
+}
+
+func (b *tencentCloudBackend) DeleteTXTRecord(zone, fqdn, value string) error {
+	domain := unFqdn(zone)
+	name := solverutil.ExtractRecordName(fqdn, zone)
+
+	listRequest := dnspodv3.NewDescribeRecordListRequest()
+	listRequest.Domain = common.StringPtr(domain)
+	listRequest.Subdomain = common.StringPtr(name)
+	listRequest.RecordType = common.StringPtr("TXT")
+
+	response, err := b.client.DescribeRecordList(listRequest)
+	if err != nil {
+		if sdkErr, ok := err.(*tcerrors.TencentCloudSDKError); ok && sdkErr.Code == "ResourceNotFound.NoDataOfRecord" {
+			return nil
+		}
+		return fmt.Errorf("tencentcloud dnspod API call failed: %v", err)
+	}
+
+	for _, record := range response.Response.RecordList {
+		if record.Value == nil || *record.Value != value {
+			continue
+		}
+
+		deleteRequest := dnspodv3.NewDeleteRecordRequest()
+		deleteRequest.Domain = common.StringPtr(domain)
+		deleteRequest.RecordId = record.RecordId
+		if _, err := b.client.DeleteRecord(deleteRequest); err != nil {
+			return fmt.Errorf("tencentcloud dnspod API call failed: %v", err)
+		}
+	}
+
+	return nil
+}
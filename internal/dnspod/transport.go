@@ -0,0 +1,134 @@
+package dnspod
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"k8s.io/klog/v2"
+)
+
+// Version is the webhook's build version, embedded in the User-Agent sent
+// with every DNSPod API call. Overridden at build time via
+// -ldflags "-X .../internal/dnspod.Version=...".
+var Version = "dev"
+
+// dnspodGoVersion is the version of the dnspod-go client library this
+// webhook vendors; dnspod-go does not expose it at runtime, so it is kept
+// here in sync with go.mod.
+const dnspodGoVersion = "0.3.0"
+
+var (
+	apiRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dnspod_api_requests_total",
+		Help: "Total number of DNSPod API requests made by the webhook, by operation and HTTP status.",
+	}, []string{"op", "status"})
+
+	apiRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dnspod_api_request_duration_seconds",
+		Help:    "Latency of DNSPod API requests made by the webhook, by operation.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"op"})
+)
+
+// userAgent builds the User-Agent header sent with every DNSPod API call,
+// e.g. "cert-manager-webhook-dnspod/1.2.3 (linux/amd64) dnspod-go/0.3.0".
+func userAgent() string {
+	return "cert-manager-webhook-dnspod/" + Version +
+		" (" + runtime.GOOS + "/" + runtime.GOARCH + ") dnspod-go/" + dnspodGoVersion
+}
+
+// loggingTransport wraps an http.RoundTripper to set the webhook's
+// User-Agent on every legacy-API (regionCN/regionIntl) DNSPod call and emit
+// structured logs and Prometheus metrics for it. The Tencent Cloud v3
+// backend is not routed through this transport; its SDK manages its own
+// HTTP client. Request/response bodies are never logged, so no credential
+// redaction is needed there; the LoginToken itself only ever appears in the
+// (unlogged) request body, never in the URL or headers.
+type loggingTransport struct {
+	next http.RoundTripper
+}
+
+func newLoggingTransport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &loggingTransport{next: next}
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.Header.Set("User-Agent", userAgent())
+
+	op := operationName(req.URL)
+	recordName := recordNameParam(req)
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	latency := time.Since(start)
+
+	status := "error"
+	if resp != nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+
+	klog.V(4).InfoS("dnspod API call",
+		"op", op,
+		"recordName", recordName,
+		"latency", latency,
+		"status", status,
+	)
+
+	apiRequestsTotal.WithLabelValues(op, status).Inc()
+	apiRequestDuration.WithLabelValues(op).Observe(latency.Seconds())
+
+	return resp, err
+}
+
+// recordNameParam extracts the "sub_domain" (dnspod-go's name for the
+// record name) parameter from the request, reading it from the URL query
+// for GET requests or from the form-encoded POST body that dnspod-go
+// actually sends everything else as -- restoring req.Body afterwards so the
+// real request still goes out intact.
+func recordNameParam(req *http.Request) string {
+	if name := req.URL.Query().Get("sub_domain"); name != "" {
+		return name
+	}
+
+	if req.Body == nil || req.Method != http.MethodPost {
+		return ""
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return ""
+	}
+
+	return values.Get("sub_domain")
+}
+
+// operationName derives a short metrics/log label from the dnspod-go
+// request path, e.g. "/Domain.List" -> "Domain.List".
+func operationName(u *url.URL) string {
+	p := u.Path
+	for len(p) > 0 && p[0] == '/' {
+		p = p[1:]
+	}
+	if p == "" {
+		return "unknown"
+	}
+	return p
+}
@@ -0,0 +1,339 @@
+// Package huaweidns implements the "huaweidnschallenger" DNS01 solver,
+// backed by Huawei Cloud DNS.
+package huaweidns
+
+import (
+	"encoding/json"
+	"fmt"
+
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/huaweicloud/huaweicloud-sdk-go-v3/core/auth/basic"
+	dnssdk "github.com/huaweicloud/huaweicloud-sdk-go-v3/services/dns/v2"
+	"github.com/huaweicloud/huaweicloud-sdk-go-v3/services/dns/v2/model"
+	dnsregion "github.com/huaweicloud/huaweicloud-sdk-go-v3/services/dns/v2/region"
+
+	"github.com/jetstack/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+
+	"github.com/signcl/cert-manager-webhook-dnspod/internal/solverutil"
+)
+
+const defaultRegionID = "cn-north-1"
+
+type apiTokenSecretRef struct {
+	name      string
+	namespace string
+}
+
+// customDNSProviderConfig is decoded from the Issuer's solver config.
+type customDNSProviderConfig struct {
+	APITokenSecret apiTokenSecretRef `json:"apiTokenSecret"`
+	TTL            int               `json:"ttl"`
+	RegionID       string            `json:"regionId"`
+
+	// IssuerCAAIdentity is the CAA issuer value Present checks the resolved
+	// FQDN's CAA RRset against before creating the challenge record.
+	// Defaults to solverutil.DefaultIssuerCAAIdentity.
+	IssuerCAAIdentity string `json:"issuerCAAIdentity"`
+}
+
+// Solver implements the provider-specific logic needed to 'present' an ACME
+// challenge TXT record with Huawei Cloud DNS.
+type Solver struct {
+	client    *kubernetes.Clientset
+	huaweiDNS *solverutil.ClientCache
+}
+
+// NewSolver returns a Huawei Cloud DNS DNS01 solver, ready to be registered
+// with cmd.RunWebhookServer.
+func NewSolver() *Solver {
+	return &Solver{}
+}
+
+// Name is used as the name for this DNS solver when referencing it on the
+// ACME Issuer resource.
+func (c *Solver) Name() string {
+	return "huaweidnschallenger"
+}
+
+// Present is responsible for actually presenting the DNS record with the
+// DNS provider.
+func (c *Solver) Present(ch *v1alpha1.ChallengeRequest) error {
+	cfg, err := loadConfig(ch.Config)
+	if err != nil {
+		return err
+	}
+
+	if err := solverutil.CheckCAA(ch.DNSName, ch.ResolvedFQDN, ch.ResolvedZone, cfg.IssuerCAAIdentity); err != nil {
+		return err
+	}
+
+	client, err := c.getClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	zoneID, err := findZoneID(client, ch.ResolvedZone)
+	if err != nil {
+		return err
+	}
+
+	ttl := int32(cfg.TTL)
+	value := quote(ch.Key)
+
+	// Present must tolerate being called multiple times with the same
+	// value -- and Huawei DNS rejects a second CreateRecordSet for a name
+	// that already has one, so find and update/append to any existing
+	// recordset instead of always creating.
+	existing, err := findRecordSet(client, zoneID, ch.ResolvedFQDN, "TXT")
+	if err != nil {
+		return err
+	}
+
+	if existing == nil {
+		request := &model.CreateRecordSetRequest{
+			ZoneId: zoneID,
+			Body: &model.CreateRecordSetRequestBody{
+				Name:    ch.ResolvedFQDN,
+				Type:    "TXT",
+				Records: []string{value},
+				Ttl:     &ttl,
+			},
+		}
+		if _, err := client.CreateRecordSet(request); err != nil {
+			return fmt.Errorf("huawei dns API call failed: %v", err)
+		}
+		return nil
+	}
+
+	for _, record := range *existing.Records {
+		if record == value {
+			// Already present from a previous Present call -- nothing to do.
+			return nil
+		}
+	}
+
+	updateRequest := &model.UpdateRecordSetRequest{
+		ZoneId:      zoneID,
+		RecordsetId: *existing.Id,
+		Body: &model.UpdateRecordSetReq{
+			Name:    ch.ResolvedFQDN,
+			Type:    "TXT",
+			Records: append(*existing.Records, value),
+			Ttl:     &ttl,
+		},
+	}
+	if _, err := client.UpdateRecordSet(updateRequest); err != nil {
+		return fmt.Errorf("huawei dns API call failed: %v", err)
+	}
+
+	return nil
+}
+
+// findRecordSet returns the recordset named name of the given type in
+// zoneID, or nil if none exists yet.
+func findRecordSet(client *dnssdk.DnsClient, zoneID, name, recordType string) (*model.ListRecordSetsWithLine, error) {
+	listRequest := &model.ListRecordSetsByZoneRequest{
+		ZoneId: zoneID,
+		Name:   &name,
+		Type:   &recordType,
+	}
+	response, err := client.ListRecordSetsByZone(listRequest)
+	if err != nil {
+		return nil, fmt.Errorf("huawei dns API call failed: %v", err)
+	}
+
+	if response.Recordsets == nil {
+		return nil, nil
+	}
+	for _, recordSet := range *response.Recordsets {
+		if recordSet.Name != nil && *recordSet.Name == name {
+			rs := recordSet
+			return &rs, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// CleanUp should delete the relevant TXT record from the DNS provider console.
+func (c *Solver) CleanUp(ch *v1alpha1.ChallengeRequest) error {
+	cfg, err := loadConfig(ch.Config)
+	if err != nil {
+		return err
+	}
+
+	client, err := c.getClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	zoneID, err := findZoneID(client, ch.ResolvedZone)
+	if err != nil {
+		return err
+	}
+
+	existing, err := findRecordSet(client, zoneID, ch.ResolvedFQDN, "TXT")
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return nil
+	}
+
+	value := quote(ch.Key)
+	remaining := make([]string, 0, len(*existing.Records))
+	for _, record := range *existing.Records {
+		if record != value {
+			remaining = append(remaining, record)
+		}
+	}
+
+	if len(remaining) == len(*existing.Records) {
+		// ch.Key wasn't in this recordset -- nothing to clean up.
+		return nil
+	}
+
+	if len(remaining) == 0 {
+		// ch.Key was the sole value: the recordset itself goes away.
+		deleteRequest := &model.DeleteRecordSetRequest{ZoneId: zoneID, RecordsetId: *existing.Id}
+		if _, err := client.DeleteRecordSet(deleteRequest); err != nil {
+			return fmt.Errorf("huawei dns API call failed: %v", err)
+		}
+		return nil
+	}
+
+	// Other challenge values (e.g. a concurrent wildcard + base domain
+	// validation) still need this recordset -- only drop ch.Key from it.
+	updateRequest := &model.UpdateRecordSetRequest{
+		ZoneId:      zoneID,
+		RecordsetId: *existing.Id,
+		Body: &model.UpdateRecordSetReq{
+			Name:    ch.ResolvedFQDN,
+			Type:    "TXT",
+			Records: remaining,
+			Ttl:     existing.Ttl,
+		},
+	}
+	if _, err := client.UpdateRecordSet(updateRequest); err != nil {
+		return fmt.Errorf("huawei dns API call failed: %v", err)
+	}
+
+	return nil
+}
+
+// Initialize will be called when the webhook first starts.
+func (c *Solver) Initialize(kubeClientConfig *rest.Config, stopCh <-chan struct{}) error {
+	cl, err := kubernetes.NewForConfig(kubeClientConfig)
+	if err != nil {
+		return err
+	}
+	c.client = cl
+	c.huaweiDNS = solverutil.NewClientCache()
+
+	return nil
+}
+
+func (c *Solver) getClient(cfg customDNSProviderConfig) (*dnssdk.DnsClient, error) {
+	secretNS := cfg.APITokenSecret.namespace
+	secretName := cfg.APITokenSecret.name
+	secretFQN := solverutil.SecretFQN(secretNS, secretName)
+
+	secret, err := solverutil.GetSecret(c.client, secretNS, secretName)
+	if err != nil {
+		return nil, err
+	}
+
+	if cached, ok := c.huaweiDNS.Get(secretFQN, secret.ResourceVersion); ok {
+		return cached.(*dnssdk.DnsClient), nil
+	}
+
+	accessKey, ok := secret.Data["accessKey"]
+	if !ok {
+		return nil, fmt.Errorf("no `accessKey` in secret '%s'", secretFQN)
+	}
+	secretKey, ok := secret.Data["secretKey"]
+	if !ok {
+		return nil, fmt.Errorf("no `secretKey` in secret '%s'", secretFQN)
+	}
+
+	regionID := cfg.RegionID
+	if regionID == "" {
+		regionID = defaultRegionID
+	}
+
+	credentials := basic.NewCredentialsBuilder().
+		WithAk(string(accessKey)).
+		WithSk(string(secretKey)).
+		Build()
+
+	region, err := dnsregion.SafeValueOf(regionID)
+	if err != nil {
+		return nil, fmt.Errorf("unknown huawei cloud region %q: %v", regionID, err)
+	}
+
+	hcClient, err := dnssdk.DnsClientBuilder().
+		WithRegion(region).
+		WithCredential(credentials).
+		SafeBuild()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build huawei dns client: %v", err)
+	}
+	client := dnssdk.NewDnsClient(hcClient)
+
+	c.huaweiDNS.Set(secretFQN, secret.ResourceVersion, client)
+
+	return client, nil
+}
+
+func findZoneID(client *dnssdk.DnsClient, zone string) (string, error) {
+	authZone, err := solverutil.AuthoritativeZone(zone)
+	if err != nil {
+		return "", err
+	}
+
+	name := authZone
+	request := &model.ListPublicZonesRequest{Name: &name}
+	response, err := client.ListPublicZones(request)
+	if err != nil {
+		return "", fmt.Errorf("huawei dns API call failed: %v", err)
+	}
+
+	if response.Zones == nil {
+		return "", fmt.Errorf("zone %s not found in huawei dns", authZone)
+	}
+	for _, z := range *response.Zones {
+		if *z.Name == authZone {
+			return *z.Id, nil
+		}
+	}
+
+	return "", fmt.Errorf("zone %s not found in huawei dns", authZone)
+}
+
+// quote wraps the challenge key in double quotes, as Huawei Cloud DNS
+// expects TXT record values to be provided with surrounding quotes.
+func quote(value string) string {
+	return `"` + value + `"`
+}
+
+// loadConfig decodes the JSON configuration into the typed config struct.
+func loadConfig(cfgJSON *extapi.JSON) (customDNSProviderConfig, error) {
+	cfg := customDNSProviderConfig{
+		APITokenSecret: apiTokenSecretRef{
+			name:      "huaweidns-credentials",
+			namespace: "default",
+		},
+		TTL: solverutil.DefaultTTL,
+	}
+	if cfgJSON == nil {
+		return cfg, nil
+	}
+	if err := json.Unmarshal(cfgJSON.Raw, &cfg); err != nil {
+		return cfg, fmt.Errorf("error decoding solver config: %v", err)
+	}
+
+	return cfg, nil
+}
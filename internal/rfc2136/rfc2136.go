@@ -0,0 +1,190 @@
+// Package rfc2136 implements the "rfc2136challenger" DNS01 solver, a
+// generic provider for any nameserver that accepts RFC 2136 dynamic
+// updates (e.g. BIND, PowerDNS, Knot).
+package rfc2136
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/miekg/dns"
+
+	"github.com/jetstack/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+
+	"github.com/signcl/cert-manager-webhook-dnspod/internal/solverutil"
+)
+
+type apiTokenSecretRef struct {
+	name      string
+	namespace string
+}
+
+// customDNSProviderConfig is decoded from the Issuer's solver config. Unlike
+// the other solvers here, RFC 2136 has no account to look up -- the
+// nameserver address is part of the config, and the referenced secret holds
+// only the TSIG key.
+type customDNSProviderConfig struct {
+	// Nameserver is the "host:port" of the authoritative nameserver to send
+	// the dynamic update to.
+	Nameserver    string            `json:"nameserver"`
+	TSIGKeyName   string            `json:"tsigKeyName"`
+	TSIGAlgorithm string            `json:"tsigAlgorithm"`
+	TSIGSecretRef apiTokenSecretRef `json:"tsigSecretSecretRef"`
+	TTL           int               `json:"ttl"`
+
+	// IssuerCAAIdentity is the CAA issuer value Present checks the resolved
+	// FQDN's CAA RRset against before creating the challenge record.
+	// Defaults to solverutil.DefaultIssuerCAAIdentity.
+	IssuerCAAIdentity string `json:"issuerCAAIdentity"`
+}
+
+// Solver implements the provider-specific logic needed to 'present' an ACME
+// challenge TXT record via RFC 2136 dynamic update.
+type Solver struct {
+	client *kubernetes.Clientset
+}
+
+// NewSolver returns an RFC 2136 DNS01 solver, ready to be registered with
+// cmd.RunWebhookServer.
+func NewSolver() *Solver {
+	return &Solver{}
+}
+
+// Name is used as the name for this DNS solver when referencing it on the
+// ACME Issuer resource.
+func (c *Solver) Name() string {
+	return "rfc2136challenger"
+}
+
+// Present is responsible for actually presenting the DNS record with the
+// DNS provider.
+func (c *Solver) Present(ch *v1alpha1.ChallengeRequest) error {
+	cfg, err := loadConfig(ch.Config)
+	if err != nil {
+		return err
+	}
+
+	if err := solverutil.CheckCAA(ch.DNSName, ch.ResolvedFQDN, ch.ResolvedZone, cfg.IssuerCAAIdentity); err != nil {
+		return err
+	}
+
+	msg, client, err := c.newUpdateMsg(cfg, ch.ResolvedZone)
+	if err != nil {
+		return err
+	}
+
+	rr, err := dns.NewRR(fmt.Sprintf("%s %d TXT %q", ch.ResolvedFQDN, cfg.TTL, ch.Key))
+	if err != nil {
+		return fmt.Errorf("failed to build TXT RR: %v", err)
+	}
+	msg.Insert([]dns.RR{rr})
+
+	return send(client, msg, cfg.Nameserver)
+}
+
+// CleanUp should delete the relevant TXT record from the DNS provider console.
+func (c *Solver) CleanUp(ch *v1alpha1.ChallengeRequest) error {
+	cfg, err := loadConfig(ch.Config)
+	if err != nil {
+		return err
+	}
+
+	msg, client, err := c.newUpdateMsg(cfg, ch.ResolvedZone)
+	if err != nil {
+		return err
+	}
+
+	rr, err := dns.NewRR(fmt.Sprintf("%s %d TXT %q", ch.ResolvedFQDN, cfg.TTL, ch.Key))
+	if err != nil {
+		return fmt.Errorf("failed to build TXT RR: %v", err)
+	}
+	msg.Remove([]dns.RR{rr})
+
+	return send(client, msg, cfg.Nameserver)
+}
+
+// Initialize will be called when the webhook first starts.
+func (c *Solver) Initialize(kubeClientConfig *rest.Config, stopCh <-chan struct{}) error {
+	cl, err := kubernetes.NewForConfig(kubeClientConfig)
+	if err != nil {
+		return err
+	}
+	c.client = cl
+
+	return nil
+}
+
+// newUpdateMsg builds the dns.Msg and *dns.Client to send it with, wiring up
+// the TSIG key referenced from cfg when one is configured.
+func (c *Solver) newUpdateMsg(cfg customDNSProviderConfig, zone string) (*dns.Msg, *dns.Client, error) {
+	authZone, err := solverutil.AuthoritativeZone(zone)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	msg := new(dns.Msg)
+	msg.SetUpdate(authZone)
+
+	client := new(dns.Client)
+
+	if cfg.TSIGKeyName == "" {
+		return msg, client, nil
+	}
+
+	secretNS := cfg.TSIGSecretRef.namespace
+	secretName := cfg.TSIGSecretRef.name
+	secret, err := solverutil.GetSecret(c.client, secretNS, secretName)
+	if err != nil {
+		return nil, nil, err
+	}
+	secretValue, ok := secret.Data["secret"]
+	if !ok {
+		return nil, nil, fmt.Errorf("no `secret` in secret '%s'", solverutil.SecretFQN(secretNS, secretName))
+	}
+
+	algorithm := cfg.TSIGAlgorithm
+	if algorithm == "" {
+		algorithm = dns.HmacSHA256
+	}
+
+	keyFQDN := dns.Fqdn(cfg.TSIGKeyName)
+	msg.SetTsig(keyFQDN, algorithm, 300, time.Now().Unix())
+	client.TsigSecret = map[string]string{keyFQDN: string(secretValue)}
+
+	return msg, client, nil
+}
+
+func send(client *dns.Client, msg *dns.Msg, nameserver string) error {
+	reply, _, err := client.Exchange(msg, nameserver)
+	if err != nil {
+		return fmt.Errorf("rfc2136 update failed: %v", err)
+	}
+	if reply != nil && reply.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("rfc2136 update failed: server replied %s", dns.RcodeToString[reply.Rcode])
+	}
+
+	return nil
+}
+
+// loadConfig decodes the JSON configuration into the typed config struct.
+func loadConfig(cfgJSON *extapi.JSON) (customDNSProviderConfig, error) {
+	cfg := customDNSProviderConfig{
+		TTL: solverutil.DefaultTTL,
+	}
+	if cfgJSON == nil {
+		return cfg, fmt.Errorf("rfc2136 solver requires a `nameserver` to be configured")
+	}
+	if err := json.Unmarshal(cfgJSON.Raw, &cfg); err != nil {
+		return cfg, fmt.Errorf("error decoding solver config: %v", err)
+	}
+	if cfg.Nameserver == "" {
+		return cfg, fmt.Errorf("rfc2136 solver requires a `nameserver` to be configured")
+	}
+
+	return cfg, nil
+}
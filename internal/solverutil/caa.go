@@ -0,0 +1,162 @@
+package solverutil
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+
+	"github.com/jetstack/cert-manager/pkg/issuer/acme/dns/util"
+)
+
+// DefaultIssuerCAAIdentity is the CAA issuer value accepted when a solver
+// config doesn't override it.
+const DefaultIssuerCAAIdentity = "letsencrypt.org"
+
+// CheckCAA resolves the CAA RRset that governs fqdn, following RFC 8659
+// tree-climbing semantics: query fqdn, and if no CAA records are returned
+// climb one label at a time until zone's apex is reached -- the first level
+// that returns any CAA RRset is authoritative. It returns an error if that
+// RRset forbids issuance by issuerCAAIdentity, so Present can fail fast
+// instead of spending an ACME order attempt (and a possible rate-limit hit)
+// on a domain locked to a different CA.
+//
+// dnsName is the domain the certificate is actually being requested for
+// (ChallengeRequest.DNSName), used only to tell whether this is a wildcard
+// issuance -- fqdn is always the "_acme-challenge...." record name and never
+// carries a "*." prefix itself.
+//
+// An empty issuerCAAIdentity defaults to DefaultIssuerCAAIdentity.
+//
+// This is a precheck optimization, not a security boundary -- cert-manager
+// and the ACME server both re-validate CAA before issuance regardless, so a
+// lookup failure here fails open (logged, Present proceeds) rather than
+// blocking every challenge on a transient resolver hiccup.
+func CheckCAA(dnsName, fqdn, zone, issuerCAAIdentity string) error {
+	if issuerCAAIdentity == "" {
+		issuerCAAIdentity = DefaultIssuerCAAIdentity
+	}
+
+	apex, err := AuthoritativeZone(zone)
+	if err != nil {
+		klog.V(2).Infof("CAA precheck: %v; proceeding without it", err)
+		return nil
+	}
+
+	wildcard := strings.HasPrefix(dnsName, "*.")
+	name := dns.Fqdn(fqdn)
+	apex = dns.Fqdn(apex)
+
+	for {
+		records, err := lookupCAA(name)
+		if err != nil {
+			klog.V(2).Infof("CAA precheck: %v; proceeding without it", err)
+			return nil
+		}
+
+		if len(records) > 0 {
+			return evaluateCAA(records, issuerCAAIdentity, wildcard, name)
+		}
+
+		if name == apex || !strings.Contains(name, ".") {
+			return nil
+		}
+
+		_, rest, ok := splitLabel(name)
+		if !ok || rest == "" {
+			return nil
+		}
+		name = rest
+	}
+}
+
+// splitLabel removes the leftmost label from an FQDN, returning it along
+// with what's left.
+func splitLabel(fqdn string) (label, rest string, ok bool) {
+	idx := strings.Index(fqdn, ".")
+	if idx == -1 {
+		return "", "", false
+	}
+	return fqdn[:idx], fqdn[idx+1:], true
+}
+
+// evaluateCAA applies RFC 8659 issue/issuewild matching to an authoritative
+// CAA RRset discovered at name.
+func evaluateCAA(records []*dns.CAA, issuerCAAIdentity string, wildcard bool, name string) error {
+	var issue, issueWild []*dns.CAA
+	for _, record := range records {
+		switch strings.ToLower(record.Tag) {
+		case "issue":
+			issue = append(issue, record)
+		case "issuewild":
+			issueWild = append(issueWild, record)
+		}
+	}
+
+	// issuewild, when present, entirely overrides issue for wildcard names.
+	applicable := issue
+	if wildcard && len(issueWild) > 0 {
+		applicable = issueWild
+	}
+
+	if len(applicable) == 0 {
+		// CAA RRset present but contains no issue/issuewild constraint:
+		// issuance is unrestricted.
+		return nil
+	}
+
+	for _, record := range applicable {
+		if strings.EqualFold(issuerDomainName(record.Value), issuerCAAIdentity) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("CAA record at %s forbids issuance by %q", util.UnFqdn(name), issuerCAAIdentity)
+}
+
+// issuerDomainName extracts the issuer-domain-name token from a CAA
+// issue/issuewild property value, per RFC 8659 section 4.2: everything
+// before the first ";" is the issuer domain name, with any
+// semicolon-separated parameters (e.g. "; validationmethods=dns-01", ";
+// accounturi=...") ignored for matching purposes. A value of "" (no issuer
+// domain name) means "no issuance permitted" and never matches.
+func issuerDomainName(value string) string {
+	if idx := strings.IndexByte(value, ';'); idx != -1 {
+		value = value[:idx]
+	}
+	return strings.TrimSpace(value)
+}
+
+// lookupCAA queries cert-manager's recursive nameservers for the CAA RRset
+// at name, trying each in turn and only failing once all of them have.
+func lookupCAA(name string) ([]*dns.CAA, error) {
+	if len(util.RecursiveNameservers) == 0 {
+		return nil, fmt.Errorf("no recursive nameservers configured")
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, dns.TypeCAA)
+	msg.RecursionDesired = true
+
+	client := new(dns.Client)
+
+	var lastErr error
+	for _, nameserver := range util.RecursiveNameservers {
+		reply, _, err := client.Exchange(msg, nameserver)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		var records []*dns.CAA
+		for _, rr := range reply.Answer {
+			if caa, ok := rr.(*dns.CAA); ok {
+				records = append(records, caa)
+			}
+		}
+		return records, nil
+	}
+
+	return nil, fmt.Errorf("CAA lookup for %s failed against all %d nameserver(s): %v", name, len(util.RecursiveNameservers), lastErr)
+}
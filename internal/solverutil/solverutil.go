@@ -0,0 +1,93 @@
+// Package solverutil holds the plumbing shared by every DNS-01 solver in
+// this webhook: resolving the Secret referenced by an Issuer/ClusterIssuer,
+// caching whatever client each provider builds from it (keyed by the
+// secret's resourceVersion so rotated credentials are picked up without a
+// restart), TTL defaulting and the handful of string-munging helpers every
+// provider needs to turn a ResolvedFQDN into the record name their API
+// expects.
+package solverutil
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/jetstack/cert-manager/pkg/issuer/acme/dns/util"
+)
+
+// DefaultTTL is used whenever a solver config does not specify one.
+const DefaultTTL = 600
+
+// SecretFQN returns the "namespace/name" identifier used to key the client
+// cache for a secret reference.
+func SecretFQN(namespace, name string) string {
+	return fmt.Sprintf("%s/%s", namespace, name)
+}
+
+// GetSecret fetches the named secret, used by every provider to resolve the
+// credentials referenced from its solver config.
+func GetSecret(client kubernetes.Interface, namespace, name string) (*corev1.Secret, error) {
+	return client.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+}
+
+// ClientCache caches one client value per secret, keyed by secretFQN, and
+// invalidates the entry whenever the secret's resourceVersion changes. The
+// cached value is provider-defined (the dnspod solver's backend, an SDK client, etc.),
+// so it is stored as interface{}.
+type ClientCache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	secretVersion string
+	client        interface{}
+}
+
+// NewClientCache returns an empty cache, ready to use.
+func NewClientCache() *ClientCache {
+	return &ClientCache{entries: make(map[string]cacheEntry)}
+}
+
+// Get returns the cached client for secretFQN if it is still fresh for
+// secretVersion.
+func (c *ClientCache) Get(secretFQN, secretVersion string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[secretFQN]
+	if !ok || entry.secretVersion != secretVersion {
+		return nil, false
+	}
+	return entry.client, true
+}
+
+// Set stores client for secretFQN, tagged with secretVersion.
+func (c *ClientCache) Set(secretFQN, secretVersion string, client interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[secretFQN] = cacheEntry{secretVersion: secretVersion, client: client}
+}
+
+// ExtractRecordName strips the zone suffix from fqdn, the way every
+// provider's Present/CleanUp needs the record name rather than the full
+// ResolvedFQDN.
+func ExtractRecordName(fqdn, zone string) string {
+	if idx := strings.Index(fqdn, "."+zone); idx != -1 {
+		return fqdn[:idx]
+	}
+
+	return util.UnFqdn(fqdn)
+}
+
+// AuthoritativeZone resolves the zone actually hosted at the DNS provider
+// for the given challenge zone, climbing the label tree via recursive
+// nameserver lookups the same way cert-manager's own providers do.
+func AuthoritativeZone(zone string) (string, error) {
+	return util.FindZoneByFqdn(zone, util.RecursiveNameservers)
+}